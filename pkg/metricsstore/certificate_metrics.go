@@ -0,0 +1,117 @@
+package metricsstore
+
+import (
+	"time"
+)
+
+// CertObserver is implemented by MetricsStore and called by the certificate
+// package whenever something happens to a certificate. Backends (Tresor,
+// Vault, cert-manager, ...) depend only on this interface, not on Prometheus
+// directly.
+type CertObserver interface {
+	// ObserveIssuance records that a certificate was issued by issuer, taking duration.
+	ObserveIssuance(issuer string, duration time.Duration)
+
+	// ObserveIssuanceError records that issuer failed to issue a certificate for the given reason.
+	ObserveIssuanceError(issuer, reason string)
+
+	// ObserveRotation records that a certificate issued by issuer was rotated.
+	ObserveRotation(issuer string)
+
+	// SetExpiration records the expiration time of the certificate identified by cn and issuer.
+	SetExpiration(cn, issuer string, expiration time.Time)
+}
+
+// ObserveIssuance implements CertObserver.
+func (ms *MetricsStore) ObserveIssuance(issuer string, duration time.Duration) {
+	ms.CertIssuedCount.WithLabelValues(issuer).Inc()
+	ms.CertIssueDuration.WithLabelValues(issuer).Observe(duration.Seconds())
+}
+
+// ObserveIssuanceError implements CertObserver.
+func (ms *MetricsStore) ObserveIssuanceError(issuer, reason string) {
+	ms.CertIssueErrorCount.WithLabelValues(issuer, reason).Inc()
+}
+
+// ObserveRotation implements CertObserver.
+func (ms *MetricsStore) ObserveRotation(issuer string) {
+	ms.CertRotationCount.WithLabelValues(issuer).Inc()
+}
+
+// SetExpiration implements CertObserver.
+func (ms *MetricsStore) SetExpiration(cn, issuer string, expiration time.Time) {
+	ms.CertExpirationTimestamp.WithLabelValues(cn, issuer).Set(float64(expiration.Unix()))
+}
+
+// CertCacheEntry describes one certificate for the purposes of refreshing
+// CertExpirationTimestamp. It is satisfied by the in-memory cert cache kept
+// by the certificate package.
+type CertCacheEntry struct {
+	CommonName string
+	Issuer     string
+	Expiration time.Time
+}
+
+// CertCache is the minimal view of a certificate cache that ScanCertExpiration
+// needs. The certificate package's cache implements this without needing to
+// import Prometheus.
+type CertCache interface {
+	// ListCertificates returns the certificates currently held in the cache.
+	ListCertificates() []CertCacheEntry
+}
+
+// ScanCertExpiration periodically reads cache and refreshes
+// CertExpirationTimestamp for every certificate found, so alerts based on
+// that gauge can fire even for certificates that are never reissued or
+// rotated before they expire. It blocks until stop is closed, so callers
+// should run it in its own goroutine; StartCertScan does this for you.
+func (ms *MetricsStore) ScanCertExpiration(cache CertCache, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, entry := range cache.ListCertificates() {
+				ms.SetExpiration(entry.CommonName, entry.Issuer, entry.Expiration)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// StartCertScan launches the background goroutine that keeps
+// CertExpirationTimestamp fresh by calling ScanCertExpiration against cache
+// every interval. It is a no-op if a scan is already running; call
+// StopCertScan, or Stop, to end it.
+func (ms *MetricsStore) StartCertScan(cache CertCache, interval time.Duration) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.certScanStop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	ms.certScanStop = stop
+	go ms.ScanCertExpiration(cache, interval, stop)
+}
+
+// StopCertScan stops the background goroutine started by StartCertScan. It is
+// a no-op if no scan is running.
+func (ms *MetricsStore) StopCertScan() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.stopCertScanLocked()
+}
+
+// stopCertScanLocked stops the running cert-expiration scan, if any. Callers
+// must hold ms.mu.
+func (ms *MetricsStore) stopCertScanLocked() {
+	if ms.certScanStop == nil {
+		return
+	}
+	close(ms.certScanStop)
+	ms.certScanStop = nil
+}
@@ -0,0 +1,92 @@
+package metricsstore
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/tools/metrics"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// registerClientGoOnce ensures the process-global client-go metrics hooks
+// (workqueue.SetProvider and metrics.Register) are installed at most once.
+// client-go only supports one active provider per process, so the hooks
+// themselves never target a specific MetricsStore: they forward through
+// activeClientGoStore below, which registerClientGoMetrics repoints at
+// whichever MetricsStore last called Start. Once another store calls Start, an
+// earlier store no longer receives client-go metrics until it is stopped and
+// started again; stopping a store does not hand them back to another one.
+var registerClientGoOnce sync.Once
+
+// activeClientGoStore is the MetricsStore that client-go's workqueue and REST
+// client metrics are currently being recorded against.
+var activeClientGoStore atomic.Pointer[MetricsStore]
+
+// registerClientGoMetrics makes ms the target of client-go's workqueue and
+// REST client metrics, installing the process-global hooks on first use.
+func registerClientGoMetrics(ms *MetricsStore) {
+	activeClientGoStore.Store(ms)
+
+	registerClientGoOnce.Do(func() {
+		workqueue.SetProvider(&workqueueMetricsProvider{})
+
+		metrics.Register(metrics.RegisterOpts{
+			RequestLatency: &restClientLatencyAdapter{},
+			RequestResult:  &restClientResultAdapter{},
+		})
+	})
+}
+
+// workqueueMetricsProvider implements workqueue.MetricsProvider by recording
+// every workqueue metric on the active MetricsStore, labelled by queue name.
+// client-go asks for these metrics once, when a queue is created, so each
+// queue keeps recording on the store that was active at that moment.
+type workqueueMetricsProvider struct{}
+
+func (p *workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return activeClientGoStore.Load().WorkqueueDepth.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return activeClientGoStore.Load().WorkqueueAdds.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return activeClientGoStore.Load().WorkqueueLatency.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return activeClientGoStore.Load().WorkqueueWorkDuration.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return activeClientGoStore.Load().WorkqueueUnfinishedWork.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return activeClientGoStore.Load().WorkqueueLongestRunningProcessor.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return activeClientGoStore.Load().WorkqueueRetries.WithLabelValues(name)
+}
+
+// restClientLatencyAdapter implements client-go's tools/metrics.LatencyMetric.
+type restClientLatencyAdapter struct{}
+
+func (a *restClientLatencyAdapter) Observe(_ context.Context, verb string, _ url.URL, latency time.Duration) {
+	// Only the verb is used as a label: the URL carries object names (e.g.
+	// /api/v1/namespaces/osm-system/pods/osm-controller-abc123) and would
+	// give this metric unbounded cardinality.
+	activeClientGoStore.Load().RestClientRequestLatency.WithLabelValues(verb).Observe(latency.Seconds())
+}
+
+// restClientResultAdapter implements client-go's tools/metrics.ResultMetric.
+type restClientResultAdapter struct{}
+
+func (a *restClientResultAdapter) Increment(_ context.Context, code, method, host string) {
+	activeClientGoStore.Load().RestClientRequestResult.WithLabelValues(code, method, host).Inc()
+}
@@ -2,6 +2,8 @@ package metricsstore
 
 import (
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -18,65 +20,225 @@ type MetricsStore struct {
 	/*
 	 * K8s metrics
 	 */
-	// K8sAPIEventCounter is the metric counter for the number of K8s API events
+	// K8sAPIEventCounter is the metric counter for the number of K8s API events.
+	//
+	// Deprecated: superseded by K8sAPIEventCount, which carries the kind,
+	// namespace and event labels. Kept for one release so existing dashboards
+	// built on the unlabelled counter keep working.
 	K8sAPIEventCounter prometheus.Counter
 
+	// K8sAPIEventCount is the metric counter for the number of K8s/SMI informer events, labelled by resource kind, namespace and event (add, update, delete)
+	K8sAPIEventCount *prometheus.CounterVec
+
+	// K8sEventHandlingDuration tracks how long an informer event handler takes to process an event, labelled by resource kind and event
+	K8sEventHandlingDuration *prometheus.HistogramVec
+
 	/*
-	 * Proxy metrics
+	 * Proxy metrics. These are populated via the XDSObserver methods on
+	 * MetricsStore rather than being written to directly, so the ADS/SDS
+	 * servers stay decoupled from Prometheus.
 	 */
-	// ProxyConnectCount is the metric for the total number of proxies connected to the controller
-	ProxyConnectCount prometheus.Gauge
+	// ProxyConnectCount is the metric for the total number of proxies connected to the controller, labelled by proxy kind (sidecar, ingress-gateway)
+	ProxyConnectCount *prometheus.GaugeVec
+
+	// ProxyConnectErrorCount counts the number of proxies that failed to connect to the controller, labelled by reason
+	ProxyConnectErrorCount *prometheus.CounterVec
+
+	// ProxyXDSRequestCount counts xDS discovery requests received from proxies, labelled by xDS type (CDS, EDS, LDS, RDS, SDS) and whether they were ACKs or NACKs
+	ProxyXDSRequestCount *prometheus.CounterVec
+
+	// ProxyXDSResponseSendCount counts xDS discovery responses sent to proxies, labelled by xDS type and ack/nack
+	ProxyXDSResponseSendCount *prometheus.CounterVec
+
+	// ProxyXDSResponseSendDuration is the time taken to send an xDS discovery response to a proxy, labelled by xDS type
+	ProxyXDSResponseSendDuration *prometheus.HistogramVec
+
+	// ProxyConfigGenerationDuration is the time taken to build an xDS snapshot for a proxy, labelled by xDS type
+	ProxyConfigGenerationDuration *prometheus.HistogramVec
 
 	/*
 	 * Injector metrics
 	 */
-	// InjectorSidecarCount counts the number of injector webhooks dealt with over time
-	InjectorSidecarCount prometheus.Counter
+	// InjectorSidecarCount counts the number of injector webhooks dealt with over time, labelled by namespace and outcome (injected, skipped, error, opt_out)
+	InjectorSidecarCount *prometheus.CounterVec
 
 	// InjectorRqTime the histogram to track times for the injector webhook calls
 	InjectorRqTime *prometheus.HistogramVec
 
+	// InjectorPatchSize is the size, in bytes, of the JSON patch returned by the injector webhook
+	InjectorPatchSize prometheus.Histogram
+
+	// InjectorCertIssueDuration is the time taken to issue the bootstrap certificate for a sidecar
+	InjectorCertIssueDuration prometheus.Histogram
+
+	// InjectorConfigGenerationDuration is the time taken to render the Envoy bootstrap config for a sidecar
+	InjectorConfigGenerationDuration prometheus.Histogram
+
+	/*
+	 * Certificate metrics
+	 */
+	// CertIssuedCount counts the number of certificates issued, labelled by issuer (tresor, vault, cert-manager, ...)
+	CertIssuedCount *prometheus.CounterVec
+
+	// CertIssueErrorCount counts certificate issuance failures, labelled by issuer and reason
+	CertIssueErrorCount *prometheus.CounterVec
+
+	// CertIssueDuration is the time taken to issue a certificate, labelled by issuer
+	CertIssueDuration *prometheus.HistogramVec
+
+	// CertRotationCount counts the number of certificates rotated, labelled by issuer
+	CertRotationCount *prometheus.CounterVec
+
+	// CertExpirationTimestamp is the expiration time, as a Unix timestamp, of the most recently observed certificate for a given common name and issuer
+	CertExpirationTimestamp *prometheus.GaugeVec
+
+	/*
+	 * client-go metrics
+	 */
+	// WorkqueueDepth is the current depth of a client-go workqueue, labelled by queue name
+	WorkqueueDepth *prometheus.GaugeVec
+
+	// WorkqueueAdds is the total number of items added to a client-go workqueue
+	WorkqueueAdds *prometheus.CounterVec
+
+	// WorkqueueLatency tracks how long an item stays in a client-go workqueue before being processed
+	WorkqueueLatency *prometheus.HistogramVec
+
+	// WorkqueueWorkDuration tracks how long it takes to process an item popped off a client-go workqueue
+	WorkqueueWorkDuration *prometheus.HistogramVec
+
+	// WorkqueueUnfinishedWork tracks how many seconds of work has been done that is in progress and hasn't been observed by WorkqueueWorkDuration
+	WorkqueueUnfinishedWork *prometheus.GaugeVec
+
+	// WorkqueueLongestRunningProcessor tracks the number of seconds the longest running processor has been running for
+	WorkqueueLongestRunningProcessor *prometheus.GaugeVec
+
+	// WorkqueueRetries is the total number of times an item was requeued to a client-go workqueue
+	WorkqueueRetries *prometheus.CounterVec
+
+	// RestClientRequestLatency tracks the latency of Kubernetes REST API requests issued via client-go, labelled by verb.
+	// The request URL is deliberately not a label: it contains object names and would give the metric unbounded cardinality.
+	RestClientRequestLatency *prometheus.HistogramVec
+
+	// RestClientRequestResult tracks the number of Kubernetes REST API requests issued via client-go, labelled by response code, method and host
+	RestClientRequestResult *prometheus.CounterVec
+
 	// MetricsStore internals should be defined below --------
-	registry *prometheus.Registry
-}
 
-var defaultMetricsStore MetricsStore
+	// registerer is where the metrics above get registered/unregistered. It is
+	// injected by the caller so embedders can combine OSM's metrics with their
+	// own registry, and so tests can use an isolated registry instead of the
+	// global one.
+	registerer prometheus.Registerer
 
-// DefaultMetricsStore is the default metrics store
-var DefaultMetricsStore = &defaultMetricsStore
+	// gatherer is a registry private to this MetricsStore that the metrics
+	// above are always also registered against, regardless of what registerer
+	// turns out to be (it may not support gathering, e.g. a
+	// prometheus.WrapRegistererWith). Handler always serves from gatherer, so
+	// it never ends up exposing some other store's metrics.
+	gatherer *prometheus.Registry
+
+	// mu guards started and certScanStop so Start/Stop/StartCertScan/StopCertScan
+	// are safe to call more than once and from more than one goroutine.
+	mu      sync.Mutex
+	started bool
+
+	// certScanStop, when non-nil, stops the background goroutine started by StartCertScan.
+	certScanStop chan struct{}
+
+	allCollectors []prometheus.Collector
+}
+
+// NewMetricsStore returns a MetricsStore whose metrics are registered against
+// the given Registerer. Passing prometheus.NewRegistry() gives the caller an
+// isolated store; passing prometheus.DefaultRegisterer (or wrapping it) lets
+// the caller fold OSM's metrics into an existing registry.
+func NewMetricsStore(reg prometheus.Registerer) *MetricsStore {
+	ms := &MetricsStore{registerer: reg, gatherer: prometheus.NewRegistry()}
 
-func init() {
 	/*
 	 * K8s metrics
 	 */
-	defaultMetricsStore.K8sAPIEventCounter = prometheus.NewCounter(prometheus.CounterOpts{
+	ms.K8sAPIEventCounter = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: metricsRootNamespace,
 		Subsystem: "k8s",
 		Name:      "api_event_count",
 		Help:      "represents the number of events received from the Kubernetes API Server",
 	})
 
+	ms.K8sAPIEventCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "k8s",
+		Name:      "event_count",
+		Help:      "represents the number of events received from the Kubernetes API Server, by resource kind, namespace and event",
+	}, []string{"kind", "namespace", "event"})
+
+	ms.K8sEventHandlingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "k8s",
+		Name:      "event_handling_duration_seconds",
+		Help:      "How long an informer event handler takes to process an event",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind", "event"})
+
 	/*
 	 * Proxy metrics
 	 */
-	defaultMetricsStore.ProxyConnectCount = prometheus.NewGauge(prometheus.GaugeOpts{
+	ms.ProxyConnectCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: metricsRootNamespace,
 		Subsystem: "proxy",
 		Name:      "connect_count",
 		Help:      "represents the number of proxies connected to OSM controller",
-	})
+	}, []string{"kind"})
+
+	ms.ProxyConnectErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "proxy",
+		Name:      "connect_errors_total",
+		Help:      "Total number of proxies that failed to connect to OSM controller",
+	}, []string{"reason"})
+
+	ms.ProxyXDSRequestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "proxy",
+		Name:      "xds_request_total",
+		Help:      "Total number of xDS discovery requests received from proxies",
+	}, []string{"type", "ack"})
+
+	ms.ProxyXDSResponseSendCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "proxy",
+		Name:      "xds_response_send_total",
+		Help:      "Total number of xDS discovery responses sent to proxies",
+	}, []string{"type", "ack"})
+
+	ms.ProxyXDSResponseSendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "proxy",
+		Name:      "xds_response_send_duration_seconds",
+		Help:      "Time taken to send an xDS discovery response to a proxy",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"type"})
+
+	ms.ProxyConfigGenerationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "proxy",
+		Name:      "config_generation_duration_seconds",
+		Help:      "Time taken to build an xDS snapshot for a proxy",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"type"})
 
 	/*
 	 * Injector metrics
 	 */
-	defaultMetricsStore.InjectorSidecarCount = prometheus.NewCounter(prometheus.CounterOpts{
+	ms.InjectorSidecarCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: metricsRootNamespace,
 		Subsystem: "injector",
 		Name:      "injector_sidecar_count",
 		Help:      "Counts the number of injector webhooks dealt with over time",
-	})
+	}, []string{"namespace", "outcome"})
 
-	defaultMetricsStore.InjectorRqTime = prometheus.NewHistogramVec(
+	ms.InjectorRqTime = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: metricsRootNamespace,
 			Subsystem: "injector",
@@ -88,29 +250,242 @@ func init() {
 			"success",
 		})
 
-	defaultMetricsStore.registry = prometheus.NewRegistry()
+	ms.InjectorPatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "injector",
+		Name:      "injector_patch_size_bytes",
+		Help:      "Size, in bytes, of the JSON patch returned by the injector webhook",
+		Buckets:   prometheus.ExponentialBuckets(64, 2, 10),
+	})
+
+	ms.InjectorCertIssueDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "injector",
+		Name:      "injector_cert_issue_duration_seconds",
+		Help:      "Time taken to issue the bootstrap certificate for a sidecar",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+
+	ms.InjectorConfigGenerationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "injector",
+		Name:      "injector_config_generation_duration_seconds",
+		Help:      "Time taken to render the Envoy bootstrap config for a sidecar",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	/*
+	 * Certificate metrics
+	 */
+	ms.CertIssuedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "cert",
+		Name:      "issued_total",
+		Help:      "Total number of certificates issued",
+	}, []string{"issuer"})
+
+	ms.CertIssueErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "cert",
+		Name:      "issue_errors_total",
+		Help:      "Total number of certificate issuance failures",
+	}, []string{"issuer", "reason"})
+
+	ms.CertIssueDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "cert",
+		Name:      "issue_duration_seconds",
+		Help:      "Time taken to issue a certificate",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"issuer"})
+
+	ms.CertRotationCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "cert",
+		Name:      "rotations_total",
+		Help:      "Total number of certificates rotated",
+	}, []string{"issuer"})
+
+	ms.CertExpirationTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "cert",
+		Name:      "expiration_timestamp_seconds",
+		Help:      "Expiration time, as a Unix timestamp, of the most recently observed certificate for a common name and issuer",
+	}, []string{"cn", "issuer"})
+
+	/*
+	 * client-go metrics
+	 */
+	ms.WorkqueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "workqueue",
+		Name:      "depth",
+		Help:      "Current depth of the workqueue",
+	}, []string{"name"})
+
+	ms.WorkqueueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "workqueue",
+		Name:      "adds_total",
+		Help:      "Total number of items added to the workqueue",
+	}, []string{"name"})
+
+	ms.WorkqueueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "workqueue",
+		Name:      "queue_duration_seconds",
+		Help:      "How long an item stays in the workqueue before being requested",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"name"})
+
+	ms.WorkqueueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "workqueue",
+		Name:      "work_duration_seconds",
+		Help:      "How long processing an item from the workqueue takes",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"name"})
+
+	ms.WorkqueueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "workqueue",
+		Name:      "unfinished_work_seconds",
+		Help:      "How many seconds of work is in progress and has not been observed by work_duration_seconds",
+	}, []string{"name"})
+
+	ms.WorkqueueLongestRunningProcessor = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "workqueue",
+		Name:      "longest_running_processor_seconds",
+		Help:      "How many seconds the longest running processor for the workqueue has been running",
+	}, []string{"name"})
+
+	ms.WorkqueueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "workqueue",
+		Name:      "retries_total",
+		Help:      "Total number of times an item was requeued to the workqueue",
+	}, []string{"name"})
+
+	ms.RestClientRequestLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "rest_client",
+		Name:      "request_latency_seconds",
+		Help:      "Latency of Kubernetes REST API requests issued by client-go",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"verb"})
+
+	ms.RestClientRequestResult = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsRootNamespace,
+		Subsystem: "rest_client",
+		Name:      "requests_total",
+		Help:      "Total number of Kubernetes REST API requests issued by client-go, by response code",
+	}, []string{"code", "method", "host"})
+
+	ms.allCollectors = []prometheus.Collector{
+		ms.K8sAPIEventCounter,
+		ms.K8sAPIEventCount,
+		ms.K8sEventHandlingDuration,
+		ms.ProxyConnectCount,
+		ms.ProxyConnectErrorCount,
+		ms.ProxyXDSRequestCount,
+		ms.ProxyXDSResponseSendCount,
+		ms.ProxyXDSResponseSendDuration,
+		ms.ProxyConfigGenerationDuration,
+		ms.InjectorSidecarCount,
+		ms.InjectorRqTime,
+		ms.InjectorPatchSize,
+		ms.InjectorCertIssueDuration,
+		ms.InjectorConfigGenerationDuration,
+		ms.CertIssuedCount,
+		ms.CertIssueErrorCount,
+		ms.CertIssueDuration,
+		ms.CertRotationCount,
+		ms.CertExpirationTimestamp,
+		ms.WorkqueueDepth,
+		ms.WorkqueueAdds,
+		ms.WorkqueueLatency,
+		ms.WorkqueueWorkDuration,
+		ms.WorkqueueUnfinishedWork,
+		ms.WorkqueueLongestRunningProcessor,
+		ms.WorkqueueRetries,
+		ms.RestClientRequestLatency,
+		ms.RestClientRequestResult,
+	}
+
+	return ms
 }
 
-// Start store
+var defaultMetricsStore = NewMetricsStore(prometheus.NewRegistry())
+
+// DefaultMetricsStore is the default metrics store
+var DefaultMetricsStore = defaultMetricsStore
+
+// Start store. Start is idempotent: calling it more than once on the same
+// MetricsStore is a no-op after the first call.
 func (ms *MetricsStore) Start() {
-	ms.registry.MustRegister(ms.K8sAPIEventCounter)
-	ms.registry.MustRegister(ms.ProxyConnectCount)
-	ms.registry.MustRegister(ms.InjectorSidecarCount)
-	ms.registry.MustRegister(ms.InjectorRqTime)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.started {
+		return
+	}
+
+	for _, c := range ms.allCollectors {
+		ms.registerer.MustRegister(c)
+		ms.gatherer.MustRegister(c)
+	}
+
+	registerClientGoMetrics(ms)
+
+	ms.started = true
 }
 
-// Stop store
+// Stop store. Stop is idempotent: calling it more than once, or calling it
+// before Start, is a no-op.
 func (ms *MetricsStore) Stop() {
-	ms.registry.Unregister(ms.K8sAPIEventCounter)
-	ms.registry.Unregister(ms.ProxyConnectCount)
-	ms.registry.Unregister(ms.InjectorSidecarCount)
-	ms.registry.Unregister(ms.InjectorRqTime)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if !ms.started {
+		return
+	}
+
+	for _, c := range ms.allCollectors {
+		ms.registerer.Unregister(c)
+		ms.gatherer.Unregister(c)
+	}
+
+	ms.stopCertScanLocked()
+
+	ms.started = false
+}
+
+// RecordK8sAPIEventCount records a single K8s/SMI informer event for kind in
+// namespace, both on the new K8sAPIEventCount counter and on the deprecated
+// unlabelled K8sAPIEventCounter, so callers don't need to remember to update
+// both while the old counter is kept around for dashboard compatibility.
+func (ms *MetricsStore) RecordK8sAPIEventCount(kind, namespace, event string) {
+	ms.K8sAPIEventCounter.Inc()
+	ms.K8sAPIEventCount.WithLabelValues(kind, namespace, event).Inc()
+}
+
+// ObserveK8sEventHandling records how long an informer event handler took to
+// process an event of kind, so slow event handlers in the k8s and SMI
+// packages become visible on K8sEventHandlingDuration.
+func (ms *MetricsStore) ObserveK8sEventHandling(kind, event string, d time.Duration) {
+	ms.K8sEventHandlingDuration.WithLabelValues(kind, event).Observe(d.Seconds())
 }
 
 // Handler return the registry
 func (ms *MetricsStore) Handler() http.Handler {
+	// Always register and gather from ms.gatherer, not from the injected
+	// registerer: the registerer may not support gathering (e.g. a
+	// prometheus.WrapRegistererWith), and even when it does, it may be shared
+	// with other registerers whose metrics this store has no business serving.
+	// Using the same registry for both halves also means the handler's own
+	// promhttp_metric_handler_requests_total/_in_flight metrics, registered
+	// into the first argument, are actually scraped from the second.
 	return promhttp.InstrumentMetricHandler(
-		ms.registry,
-		promhttp.HandlerFor(ms.registry, promhttp.HandlerOpts{}),
+		ms.gatherer,
+		promhttp.HandlerFor(ms.gatherer, promhttp.HandlerOpts{}),
 	)
 }
@@ -0,0 +1,216 @@
+package metricsstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestMetricsStore returns a MetricsStore backed by its own registry so
+// tests don't race on, or pollute, the global registry.
+func newTestMetricsStore(t *testing.T) *MetricsStore {
+	t.Helper()
+	ms := NewMetricsStore(prometheus.NewRegistry())
+	ms.Start()
+	t.Cleanup(ms.Stop)
+	return ms
+}
+
+func TestStartStopIdempotent(t *testing.T) {
+	ms := NewMetricsStore(prometheus.NewRegistry())
+
+	// Calling Start twice must not panic (MustRegister would panic on a
+	// duplicate registration).
+	ms.Start()
+	ms.Start()
+
+	// Calling Stop twice, and calling it after Start has already run, must
+	// not panic either.
+	ms.Stop()
+	ms.Stop()
+}
+
+func TestInjectorSidecarCountLabelled(t *testing.T) {
+	ms := newTestMetricsStore(t)
+
+	ms.InjectorSidecarCount.WithLabelValues("osm-system", "injected").Inc()
+	ms.InjectorSidecarCount.WithLabelValues("osm-system", "skipped").Inc()
+	ms.InjectorSidecarCount.WithLabelValues("osm-system", "skipped").Inc()
+
+	var m dto.Metric
+	if err := ms.InjectorSidecarCount.WithLabelValues("osm-system", "skipped").Write(&m); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+
+	if got, want := m.GetCounter().GetValue(), float64(2); got != want {
+		t.Errorf("got %v injector_sidecar_count{namespace=osm-system,outcome=skipped}, want %v", got, want)
+	}
+}
+
+func TestRecordK8sAPIEventCount(t *testing.T) {
+	ms := newTestMetricsStore(t)
+
+	ms.RecordK8sAPIEventCount("Pod", "osm-system", "add")
+	ms.RecordK8sAPIEventCount("Pod", "osm-system", "add")
+
+	var labelled dto.Metric
+	if err := ms.K8sAPIEventCount.WithLabelValues("Pod", "osm-system", "add").Write(&labelled); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got, want := labelled.GetCounter().GetValue(), float64(2); got != want {
+		t.Errorf("got %v k8s_event_count{kind=Pod,namespace=osm-system,event=add}, want %v", got, want)
+	}
+
+	var unlabelled dto.Metric
+	if err := ms.K8sAPIEventCounter.Write(&unlabelled); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got, want := unlabelled.GetCounter().GetValue(), float64(2); got != want {
+		t.Errorf("got %v k8s_api_event_count, want %v", got, want)
+	}
+}
+
+type fakeCertCache struct {
+	entries []CertCacheEntry
+}
+
+func (f *fakeCertCache) ListCertificates() []CertCacheEntry {
+	return f.entries
+}
+
+func TestStartCertScanRefreshesExpiration(t *testing.T) {
+	ms := newTestMetricsStore(t)
+
+	expiration := time.Unix(1234567890, 0)
+	cache := &fakeCertCache{entries: []CertCacheEntry{
+		{CommonName: "bookstore.osm-system.cluster.local", Issuer: "tresor", Expiration: expiration},
+	}}
+
+	ms.StartCertScan(cache, time.Millisecond)
+	t.Cleanup(ms.StopCertScan)
+
+	deadline := time.After(time.Second)
+	for {
+		var m dto.Metric
+		if err := ms.CertExpirationTimestamp.WithLabelValues("bookstore.osm-system.cluster.local", "tresor").Write(&m); err != nil {
+			t.Fatalf("failed to write metric: %s", err)
+		}
+		if m.GetGauge().GetValue() == float64(expiration.Unix()) {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("cert_expiration_timestamp_seconds was not refreshed by StartCertScan in time")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestStartStopCertScanIdempotent(t *testing.T) {
+	ms := newTestMetricsStore(t)
+	cache := &fakeCertCache{}
+
+	// Starting twice must not launch a second goroutine or panic.
+	ms.StartCertScan(cache, time.Minute)
+	ms.StartCertScan(cache, time.Minute)
+
+	// Stopping twice, and stopping when no scan is running, must not panic.
+	ms.StopCertScan()
+	ms.StopCertScan()
+}
+
+func TestXDSObserverHelpers(t *testing.T) {
+	ms := newTestMetricsStore(t)
+
+	ms.ObserveXDSRequest("LDS", true)
+	ms.ObserveXDSResponseSent("LDS", true, 50*time.Millisecond)
+	ms.ObserveConfigGeneration("LDS", 10*time.Millisecond)
+	ms.ObserveProxyConnectError("tls_handshake_failed")
+	ms.SetProxyConnectCount("sidecar", 3)
+
+	var reqCount dto.Metric
+	if err := ms.ProxyXDSRequestCount.WithLabelValues("LDS", "true").Write(&reqCount); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got, want := reqCount.GetCounter().GetValue(), float64(1); got != want {
+		t.Errorf("got %v xds_request_total{type=LDS,ack=true}, want %v", got, want)
+	}
+
+	var sendCount dto.Metric
+	if err := ms.ProxyXDSResponseSendCount.WithLabelValues("LDS", "true").Write(&sendCount); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got, want := sendCount.GetCounter().GetValue(), float64(1); got != want {
+		t.Errorf("got %v xds_response_send_total{type=LDS,ack=true}, want %v", got, want)
+	}
+
+	var sendDuration dto.Metric
+	if err := ms.ProxyXDSResponseSendDuration.WithLabelValues("LDS").(prometheus.Histogram).Write(&sendDuration); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got := sendDuration.GetHistogram().GetSampleCount(); got != 1 {
+		t.Errorf("got sample count %d for xds_response_send_duration_seconds{type=LDS}, want 1", got)
+	}
+
+	var configGen dto.Metric
+	if err := ms.ProxyConfigGenerationDuration.WithLabelValues("LDS").(prometheus.Histogram).Write(&configGen); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got := configGen.GetHistogram().GetSampleCount(); got != 1 {
+		t.Errorf("got sample count %d for config_generation_duration_seconds{type=LDS}, want 1", got)
+	}
+
+	var connectErr dto.Metric
+	if err := ms.ProxyConnectErrorCount.WithLabelValues("tls_handshake_failed").Write(&connectErr); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got, want := connectErr.GetCounter().GetValue(), float64(1); got != want {
+		t.Errorf("got %v connect_errors_total{reason=tls_handshake_failed}, want %v", got, want)
+	}
+
+	var connectCount dto.Metric
+	if err := ms.ProxyConnectCount.WithLabelValues("sidecar").Write(&connectCount); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got, want := connectCount.GetGauge().GetValue(), float64(3); got != want {
+		t.Errorf("got %v connect_count{kind=sidecar}, want %v", got, want)
+	}
+}
+
+func TestObserveK8sEventHandling(t *testing.T) {
+	ms := newTestMetricsStore(t)
+
+	ms.ObserveK8sEventHandling("Pod", "add", 25*time.Millisecond)
+
+	var m dto.Metric
+	if err := ms.K8sEventHandlingDuration.WithLabelValues("Pod", "add").(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatalf("failed to write metric: %s", err)
+	}
+	if got := m.GetHistogram().GetSampleCount(); got != 1 {
+		t.Errorf("got sample count %d for k8s_event_handling_duration_seconds{kind=Pod,event=add}, want 1", got)
+	}
+}
+
+func TestInjectorHistograms(t *testing.T) {
+	ms := newTestMetricsStore(t)
+
+	ms.InjectorPatchSize.Observe(512)
+	ms.InjectorCertIssueDuration.Observe(0.05)
+	ms.InjectorConfigGenerationDuration.Observe(0.02)
+
+	for name, h := range map[string]prometheus.Histogram{
+		"InjectorPatchSize":                ms.InjectorPatchSize,
+		"InjectorCertIssueDuration":        ms.InjectorCertIssueDuration,
+		"InjectorConfigGenerationDuration": ms.InjectorConfigGenerationDuration,
+	} {
+		var m dto.Metric
+		if err := h.Write(&m); err != nil {
+			t.Fatalf("%s: failed to write metric: %s", name, err)
+		}
+		if got := m.GetHistogram().GetSampleCount(); got != 1 {
+			t.Errorf("%s: got sample count %d, want 1", name, got)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package metricsstore
+
+import (
+	"strconv"
+	"time"
+)
+
+// XDSObserver is implemented by MetricsStore and called by the ADS/SDS
+// servers so every discovery request/response and snapshot build is
+// observed. Keeping it as an interface lets the xDS server package depend
+// only on this, not on Prometheus directly.
+type XDSObserver interface {
+	// ObserveXDSRequest records an xDS discovery request of the given type, labelled by whether it was an ACK.
+	ObserveXDSRequest(typeURL string, ack bool)
+
+	// ObserveXDSResponseSent records that an xDS discovery response of the given type was sent, and how long it took to send.
+	ObserveXDSResponseSent(typeURL string, ack bool, duration time.Duration)
+
+	// ObserveConfigGeneration records how long it took to build an xDS snapshot of the given type.
+	ObserveConfigGeneration(typeURL string, duration time.Duration)
+
+	// ObserveProxyConnectError records that a proxy failed to connect, for the given reason.
+	ObserveProxyConnectError(reason string)
+
+	// SetProxyConnectCount sets the number of currently connected proxies of the given kind (sidecar, ingress-gateway).
+	SetProxyConnectCount(kind string, count float64)
+}
+
+// ObserveXDSRequest implements XDSObserver.
+func (ms *MetricsStore) ObserveXDSRequest(typeURL string, ack bool) {
+	ms.ProxyXDSRequestCount.WithLabelValues(typeURL, strconv.FormatBool(ack)).Inc()
+}
+
+// ObserveXDSResponseSent implements XDSObserver.
+func (ms *MetricsStore) ObserveXDSResponseSent(typeURL string, ack bool, duration time.Duration) {
+	ackLabel := strconv.FormatBool(ack)
+	ms.ProxyXDSResponseSendCount.WithLabelValues(typeURL, ackLabel).Inc()
+	ms.ProxyXDSResponseSendDuration.WithLabelValues(typeURL).Observe(duration.Seconds())
+}
+
+// ObserveConfigGeneration implements XDSObserver.
+func (ms *MetricsStore) ObserveConfigGeneration(typeURL string, duration time.Duration) {
+	ms.ProxyConfigGenerationDuration.WithLabelValues(typeURL).Observe(duration.Seconds())
+}
+
+// ObserveProxyConnectError implements XDSObserver.
+func (ms *MetricsStore) ObserveProxyConnectError(reason string) {
+	ms.ProxyConnectErrorCount.WithLabelValues(reason).Inc()
+}
+
+// SetProxyConnectCount implements XDSObserver.
+func (ms *MetricsStore) SetProxyConnectCount(kind string, count float64) {
+	ms.ProxyConnectCount.WithLabelValues(kind).Set(count)
+}